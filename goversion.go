@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bobg/errors"
+)
+
+// GoVersionStatus classifies how a binary's embedded Go toolchain
+// version compares to the releases known to the configured proxy.
+type GoVersionStatus string
+
+const (
+	// GoVersionCurrent means the binary was built with the newest
+	// released patch in its major.minor track.
+	GoVersionCurrent GoVersionStatus = "current"
+
+	// GoVersionPatchBehind means a newer patch release exists in the
+	// same major.minor track.
+	GoVersionPatchBehind GoVersionStatus = "patch-behind"
+
+	// GoVersionMinorBehind means a newer minor (or major) release
+	// exists.
+	GoVersionMinorBehind GoVersionStatus = "minor-behind"
+
+	// GoVersionUnsupported means the binary's major.minor track no
+	// longer appears among the known releases at all.
+	GoVersionUnsupported GoVersionStatus = "unsupported"
+)
+
+// goVersionRank orders the non-current statuses from least to most
+// severe, so that -go=LEVEL can pick a severity threshold below which
+// goup stays quiet about the Go toolchain.
+var goVersionRank = map[GoVersionStatus]int{
+	GoVersionPatchBehind: 1,
+	GoVersionMinorBehind: 2,
+	GoVersionUnsupported: 3,
+}
+
+// needsGoUpdate reports whether status is severe enough to be worth
+// reporting under the given -go policy level: "patch" flags any lag at
+// all (the default), "minor" ignores patch-only lag, and "eol" flags
+// only a track that isn't supported anymore.
+func needsGoUpdate(status GoVersionStatus, level string) bool {
+	threshold := 1
+	switch level {
+	case "minor":
+		threshold = 2
+	case "eol":
+		threshold = 3
+	}
+	return goVersionRank[status] >= threshold
+}
+
+// goVersion is a parsed stable Go release version, e.g. "go1.23.7".
+type goVersion struct {
+	major, minor, patch int
+	raw                 string
+}
+
+var goVersionRE = regexp.MustCompile(`^go(\d+)\.(\d+)(?:\.(\d+))?$`)
+
+// parseGoVersion parses a stable Go release version such as "go1.23.7"
+// or "go1.23" (patch defaults to 0). It rejects betas and release
+// candidates, which don't fit the major.minor.patch model goup
+// compares against.
+func parseGoVersion(s string) (goVersion, bool) {
+	m := goVersionRE.FindStringSubmatch(s)
+	if m == nil {
+		return goVersion{}, false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	var patch int
+	if m[3] != "" {
+		patch, _ = strconv.Atoi(m[3])
+	}
+	return goVersion{major: major, minor: minor, patch: patch, raw: s}, true
+}
+
+func (v goVersion) less(o goVersion) bool {
+	if v.major != o.major {
+		return v.major < o.major
+	}
+	if v.minor != o.minor {
+		return v.minor < o.minor
+	}
+	return v.patch < o.patch
+}
+
+// goToolchainSuffix is the "<GOOS>-<GOARCH>" suffix that
+// golang.org/toolchain module versions carry for this platform.
+var goToolchainSuffix = "." + runtime.GOOS + "-" + runtime.GOARCH
+
+// releasedGoVersions extracts the stable Go release versions for this
+// platform out of the raw golang.org/toolchain module versions
+// reported by a proxy's @v/list, e.g. "v0.0.1-go1.23.7.linux-amd64".
+func releasedGoVersions(versions []string) []goVersion {
+	var out []goVersion
+	for _, v := range versions {
+		v = strings.TrimSpace(v)
+		if !strings.HasSuffix(v, goToolchainSuffix) {
+			continue
+		}
+		v = strings.TrimPrefix(v, "v0.0.1-")
+		v = strings.TrimSuffix(v, goToolchainSuffix)
+		if gv, ok := parseGoVersion(v); ok {
+			out = append(out, gv)
+		}
+	}
+	return out
+}
+
+// goVersionInfo reports the newest released patch in installed's
+// major.minor track (if that track still exists) and the newest
+// released version overall.
+func goVersionInfo(installed goVersion, released []goVersion) (latestInTrack goVersion, trackExists bool, latestOverall goVersion) {
+	for _, gv := range released {
+		if gv.major == installed.major && gv.minor == installed.minor {
+			if !trackExists || latestInTrack.less(gv) {
+				latestInTrack, trackExists = gv, true
+			}
+		}
+		if latestOverall.raw == "" || latestOverall.less(gv) {
+			latestOverall = gv
+		}
+	}
+	return latestInTrack, trackExists, latestOverall
+}
+
+// goVersionStatus classifies installed relative to the newest release
+// in its own track (latestInTrack, absent if trackExists is false) and
+// the newest release overall (latestOverall).
+func goVersionStatus(installed, latestInTrack goVersion, trackExists bool, latestOverall goVersion) GoVersionStatus {
+	if !trackExists {
+		return GoVersionUnsupported
+	}
+	if installed.less(latestInTrack) {
+		return GoVersionPatchBehind
+	}
+	if installed.less(latestOverall) {
+		return GoVersionMinorBehind
+	}
+	return GoVersionCurrent
+}
+
+// toolchainCache fetches and remembers the released Go toolchain
+// versions for this platform, as reported by the configured proxies
+// for the golang.org/toolchain module (the mechanism Go 1.21+ uses to
+// distribute toolchains). The list is the same for every file goup
+// processes in a run, so it's fetched at most once.
+type toolchainCache struct {
+	mu       sync.Mutex
+	fetched  bool
+	versions []goVersion
+	err      error
+}
+
+func (t *toolchainCache) get(ctx context.Context, proxies *proxyList) ([]goVersion, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.fetched {
+		return t.versions, t.err
+	}
+	t.fetched = true
+
+	raw, err := proxies.list(ctx, "golang.org/toolchain")
+	if err != nil {
+		t.err = errors.Wrap(err, "listing golang.org/toolchain versions")
+		return nil, t.err
+	}
+	t.versions = releasedGoVersions(raw)
+	return t.versions, nil
+}
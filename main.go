@@ -10,10 +10,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
+	"runtime"
+	"sync"
 
 	"github.com/bobg/errors"
-	"github.com/bobg/goproxyclient"
 	"github.com/bobg/mid"
 	"golang.org/x/mod/semver"
 	"golang.org/x/time/rate"
@@ -29,19 +29,18 @@ func main() {
 func run() error {
 	goproxy := os.Getenv("GOPROXY")
 	if goproxy == "" {
-		goproxy = "https://proxy.golang.org"
+		goproxy = "https://proxy.golang.org,direct"
 	}
-	parts := strings.Split(goproxy, ",")
-	if len(parts) > 1 {
-		goproxy = parts[0]
-	}
-
 	var (
 		all      bool
 		emitCmd  bool
 		emitJSON bool
 		showErrs bool
 		qps      float64
+		goLevel  string
+		verify   string
+		policy   string
+		jobs     int
 	)
 
 	flag.BoolVar(&all, "all", false, "show all files")
@@ -49,7 +48,11 @@ func run() error {
 	flag.BoolVar(&emitJSON, "json", false, "emit output as JSON")
 	flag.BoolVar(&showErrs, "errs", true, "show errors (default true, use -errs=false to suppress)")
 	flag.Float64Var(&qps, "rate", 2, "max queries per second to the proxy")
-	flag.StringVar(&goproxy, "proxy", goproxy, "Go module proxy URL")
+	flag.StringVar(&goproxy, "proxy", goproxy, "Go module proxy list (comma- or pipe-separated, as in GOPROXY)")
+	flag.StringVar(&goLevel, "go", "patch", "Go toolchain update sensitivity: patch, minor, or eol")
+	flag.StringVar(&verify, "verify", "warn", "checksum database verification: off, warn, or strict")
+	flag.StringVar(&policy, "policy", "latest", "version selection policy: latest, stable, major:vN, or track")
+	flag.IntVar(&jobs, "jobs", runtime.NumCPU(), "number of files to process concurrently when given a directory")
 	flag.Parse()
 
 	if all && emitCmd {
@@ -58,20 +61,45 @@ func run() error {
 	if emitCmd && emitJSON {
 		return fmt.Errorf("cannot specify both -cmd and -json")
 	}
+	switch goLevel {
+	case "patch", "minor", "eol":
+	default:
+		return fmt.Errorf("-go must be patch, minor, or eol, got %q", goLevel)
+	}
+	switch verify {
+	case "off", "warn", "strict":
+	default:
+		return fmt.Errorf("-verify must be off, warn, or strict, got %q", verify)
+	}
+	if jobs < 1 {
+		return fmt.Errorf("-jobs must be at least 1, got %d", jobs)
+	}
 
 	var (
 		limiter = rate.NewLimiter(rate.Limit(qps), 1)
 		lt      = mid.LimitedTransport{L: limiter}
 		hc      = &http.Client{Transport: lt}
 		ctx     = context.Background()
+		proxies = newProxyList(goproxy, os.Getenv("GONOPROXY"), os.Getenv("GOPRIVATE"), hc)
 	)
 
+	vp, err := parsePolicy(policy, proxies)
+	if err != nil {
+		return err
+	}
+
 	c := controller{
-		all:      all,
-		emitCmd:  emitCmd,
-		emitJSON: emitJSON,
-		showErrs: showErrs,
-		client:   goproxyclient.New(goproxy, hc),
+		all:        all,
+		emitCmd:    emitCmd,
+		emitJSON:   emitJSON,
+		showErrs:   showErrs,
+		goLevel:    goLevel,
+		verify:     verify,
+		proxies:    proxies,
+		toolchains: &toolchainCache{},
+		sumdb:      newSumdbVerifier(os.Getenv("GOSUMDB"), os.Getenv("GONOSUMDB"), os.Getenv("GOPRIVATE"), os.Getenv("GOINSECURE")),
+		policy:     vp,
+		jobs:       jobs,
 	}
 
 	for _, arg := range flag.Args() {
@@ -93,100 +121,207 @@ func run() error {
 }
 
 type controller struct {
-	client                           *goproxyclient.Client
+	proxies                          *proxyList
+	toolchains                       *toolchainCache
+	sumdb                            *sumdbVerifier
+	policy                           VersionPolicy
+	goLevel, verify                  string
+	jobs                             int
 	all, emitCmd, emitJSON, showErrs bool
 }
 
+// doDir analyzes every file in dir, using up to c.jobs workers, and
+// prints the results in directory-listing order once they're all
+// ready — so output ordering and interleaving don't depend on which
+// worker happens to finish first.
 func (c controller) doDir(ctx context.Context, dir string) error {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return errors.Wrapf(err, "reading %s", dir)
 	}
-	for _, entry := range entries {
-		if err := c.doFile(ctx, filepath.Join(dir, entry.Name())); err != nil {
-			return errors.Wrapf(err, "processing %s/%s", dir, entry.Name())
+
+	var (
+		results = make([]output, len(entries))
+		sem     = make(chan struct{}, c.jobs)
+		wg      sync.WaitGroup
+	)
+
+	for i, entry := range entries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, name string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = c.analyze(ctx, filepath.Join(dir, name))
+		}(i, entry.Name())
+	}
+	wg.Wait()
+
+	for i, o := range results {
+		if err := c.print(o); err != nil {
+			return errors.Wrapf(err, "processing %s/%s", dir, entries[i].Name())
 		}
 	}
 	return nil
 }
 
 type output struct {
-	File        string `json:"file"`
-	Installed   string `json:"installed"`
-	Available   string `json:"available"`
-	MainModule  string `json:"main_module"`
-	MainPackage string `json:"main_package"`
-	Error       string `json:"error,omitempty"`
+	File               string `json:"file"`
+	Installed          string `json:"installed"`
+	Available          string `json:"available"`
+	MainModule         string `json:"main_module"`
+	MainPackage        string `json:"main_package"`
+	GoVersion          string `json:"go_version,omitempty"`
+	GoVersionAvailable string `json:"go_version_available,omitempty"`
+	GoVersionStatus    string `json:"go_version_status,omitempty"`
+	SumdbStatus        string `json:"sumdb_status,omitempty"`
+	Policy             string `json:"policy,omitempty"`
+	Error              string `json:"error,omitempty"`
+}
+
+// doFile analyzes and prints the result for a single file.
+func (c controller) doFile(ctx context.Context, file string) error {
+	return c.print(c.analyze(ctx, file))
 }
 
-func (c controller) doFile(ctx context.Context, file string) (err error) {
+// analyze inspects file and returns the result. It never returns an
+// error directly; any problem is recorded in the result's Error field
+// instead, so analyze is a pure, order-independent computation safe
+// to run concurrently across multiple files (as doDir's worker pool
+// does).
+func (c controller) analyze(ctx context.Context, file string) output {
 	o := output{
 		File: file,
 	}
 
-	defer func() {
-		if !c.showErrs && o.Error != "" {
-			return
-		}
-		if !c.emitJSON && o.Error != "" {
-			fmt.Fprintf(os.Stderr, "%s: %s\n", file, o.Error)
-			return
-		}
-		if !c.all || c.emitCmd {
-			if !semver.IsValid(o.Installed) || !semver.IsValid(o.Available) {
-				return
-			}
-			if semver.Compare(o.Installed, o.Available) >= 0 {
-				return
-			}
-		}
-		if c.emitJSON {
-			enc := json.NewEncoder(os.Stdout)
-			enc.SetIndent("", "  ")
-			err = enc.Encode(o)
-			return
-		}
-		if c.emitCmd {
-			fmt.Printf("go install %s@%s\n", o.MainPackage, o.Available)
-			return
-		}
-		fmt.Printf("%s:", file)
-		if o.MainPackage != "" {
-			fmt.Printf(" package=%s", o.MainPackage)
-		}
-		if o.Installed != "" {
-			fmt.Printf(" installed=%s", o.Installed)
-		}
-		if o.Available != "" {
-			fmt.Printf(" available=%s", o.Available)
-		}
-		fmt.Print("\n")
-	}()
-
 	info, err := buildinfo.ReadFile(file)
 	if err != nil {
-		err = errors.Wrapf(err, "reading %s", file)
-		o.Error = err.Error()
-		return nil
+		o.Error = errors.Wrapf(err, "reading %s", file).Error()
+		return o
 	}
 
 	o.Installed = info.Main.Version
 	o.MainModule = info.Main.Path
 	o.MainPackage = info.Path
 
-	// xxx check info.GoVersion, is it out of date?
+	o.GoVersion = info.GoVersion
+	if installed, ok := parseGoVersion(info.GoVersion); ok {
+		if released, err := c.toolchains.get(ctx, c.proxies); err == nil {
+			latestInTrack, trackExists, latestOverall := goVersionInfo(installed, released)
+			status := goVersionStatus(installed, latestInTrack, trackExists, latestOverall)
+			o.GoVersionStatus = string(status)
+			if status == GoVersionPatchBehind {
+				o.GoVersionAvailable = latestInTrack.raw
+			} else {
+				o.GoVersionAvailable = latestOverall.raw
+			}
+		}
+	}
 
-	versions, err := c.client.List(ctx, info.Main.Path)
+	versions, err := c.proxies.list(ctx, info.Main.Path)
 	if err != nil {
-		err = errors.Wrapf(err, "listing versions for %s", info.Main.Path)
-		o.Error = err.Error()
-		return nil
+		if isNotFound(err) {
+			// No proxy has ever heard of this module; treat that the same
+			// as "no versions returned" rather than a hard error.
+			return o
+		}
+		o.Error = errors.Wrapf(err, "listing versions for %s", info.Main.Path).Error()
+		return o
 	}
 
+	o.Policy = c.policy.Name()
 	if len(versions) > 0 {
-		semver.Sort(versions)
-		o.Available = versions[len(versions)-1]
+		available, err := c.policy.Select(ctx, info.Main.Path, o.Installed, versions)
+		if err != nil {
+			o.Error = errors.Wrapf(err, "selecting an upgrade for %s", info.Main.Path).Error()
+			return o
+		}
+		o.Available = available
+	}
+
+	if isModuleBehind(o) && c.verify != "off" {
+		status, verr := c.sumdb.verify(ctx, c.proxies, info.Main.Path, o.Available)
+		o.SumdbStatus = status
+		if verr != nil && c.verify == "strict" {
+			o.Available = ""
+			o.Error = errors.Wrapf(verr, "verifying %s", info.Main.Path).Error()
+			return o
+		}
 	}
 
+	return o
+}
+
+// isModuleBehind reports whether o represents an actual upgrade: a
+// valid installed version strictly less than a valid available one.
+// Verification (and printing "available") is scoped to this case, not
+// to every file goup inspects, even when the policy has a pick.
+func isModuleBehind(o output) bool {
+	return semver.IsValid(o.Installed) && semver.IsValid(o.Available) && semver.Compare(o.Installed, o.Available) < 0
+}
+
+// print renders o according to the controller's output mode (JSON,
+// shell commands, or plain text), exactly as doFile used to do
+// inline before analyze and print were split apart to let doDir
+// process files concurrently.
+func (c controller) print(o output) error {
+	file := o.File
+
+	if !c.showErrs && o.Error != "" {
+		return nil
+	}
+	if !c.emitJSON && o.Error != "" {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", file, o.Error)
+		return nil
+	}
+	moduleBehind := isModuleBehind(o)
+	goBehind := needsGoUpdate(GoVersionStatus(o.GoVersionStatus), c.goLevel)
+	if (!c.all || c.emitCmd) && !moduleBehind && !goBehind {
+		return nil
+	}
+	if c.emitJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(o)
+	}
+	if c.emitCmd {
+		if moduleBehind {
+			if o.SumdbStatus == SumdbMismatch || o.SumdbStatus == SumdbError {
+				fmt.Printf("# WARNING: %s: checksum database verification %s for %s@%s, not installing\n", file, o.SumdbStatus, o.MainPackage, o.Available)
+			} else {
+				fmt.Printf("go install %s@%s\n", o.MainPackage, o.Available)
+			}
+		}
+		if goBehind {
+			fmt.Printf("# %s: Go toolchain %s available (installed %s)\n", file, o.GoVersionAvailable, o.GoVersion)
+		}
+		return nil
+	}
+	fmt.Printf("%s:", file)
+	if o.MainPackage != "" {
+		fmt.Printf(" package=%s", o.MainPackage)
+	}
+	if o.Installed != "" {
+		fmt.Printf(" installed=%s", o.Installed)
+	}
+	if o.Available != "" {
+		fmt.Printf(" available=%s", o.Available)
+	}
+	if o.Policy != "" && o.Policy != "latest" {
+		fmt.Printf(" policy=%s", o.Policy)
+	}
+	if o.GoVersion != "" {
+		fmt.Printf(" go=%s", o.GoVersion)
+	}
+	if o.GoVersionAvailable != "" {
+		fmt.Printf(" go-available=%s", o.GoVersionAvailable)
+	}
+	if o.GoVersionStatus != "" {
+		fmt.Printf(" go-status=%s", o.GoVersionStatus)
+	}
+	if o.SumdbStatus != "" && o.SumdbStatus != SumdbSkipped {
+		fmt.Printf(" sumdb=%s", o.SumdbStatus)
+	}
+	fmt.Print("\n")
 	return nil
 }
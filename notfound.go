@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/goproxyclient"
+)
+
+// NotFoundError indicates that a module, or a version of one, does not
+// exist: either the proxy returned an HTTP 404 or 410, or its @v/list
+// response was empty (which modfetch treats the same way).
+type NotFoundError struct {
+	Module string
+}
+
+// Error implements the error interface.
+func (e NotFoundError) Error() string {
+	return "module " + e.Module + " not found"
+}
+
+// Is reports whether target is os.ErrNotExist, so that
+// errors.Is(err, os.ErrNotExist) recognizes a NotFoundError.
+func (e NotFoundError) Is(target error) bool {
+	return target == os.ErrNotExist
+}
+
+// isNotFound tests whether err indicates that a module or a version of it
+// could not be found, whether that's a [goproxyclient.CodeErr] with a
+// 404 or 410 status, or a [NotFoundError] for an empty @v/list response.
+func isNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	if goproxyclient.IsNotFound(err) {
+		return true
+	}
+	var nf NotFoundError
+	return errors.As(err, &nf)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bobg/errors"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// VersionPolicy decides which of a module's available versions goup
+// should recommend as the upgrade target, selectable with the -policy
+// flag.
+type VersionPolicy interface {
+	// Name identifies the policy, as recorded in [output.Policy].
+	Name() string
+
+	// Select returns the version among versions (as returned by
+	// [proxyList.list], not necessarily sorted) that the policy
+	// recommends, or "" if none qualifies. installed is the version
+	// currently embedded in the binary, or "" if unknown.
+	Select(ctx context.Context, modpath, installed string, versions []string) (string, error)
+}
+
+// parsePolicy parses the -policy flag value into a VersionPolicy. A
+// stablePolicy is wired to proxies so it can fetch go.mod files to
+// check for retractions.
+func parsePolicy(spec string, proxies *proxyList) (VersionPolicy, error) {
+	switch {
+	case spec == "latest":
+		return latestPolicy{}, nil
+	case spec == "stable":
+		return stablePolicy{proxies: proxies}, nil
+	case spec == "track":
+		return trackPolicy{}, nil
+	case strings.HasPrefix(spec, "major:"):
+		major := strings.TrimPrefix(spec, "major:")
+		if !semver.IsValid(major + ".0.0") {
+			return nil, fmt.Errorf("invalid major version %q in -policy=major:%s", major, major)
+		}
+		return majorPolicy{major: major}, nil
+	default:
+		return nil, fmt.Errorf("-policy must be latest, stable, major:vN, or track, got %q", spec)
+	}
+}
+
+// latestPolicy recommends the highest available version, prereleases
+// included, matching goup's original behavior.
+type latestPolicy struct{}
+
+func (latestPolicy) Name() string { return "latest" }
+
+func (latestPolicy) Select(_ context.Context, _, _ string, versions []string) (string, error) {
+	return highest(versions), nil
+}
+
+// majorPolicy restricts candidates to a single major version, e.g.
+// "major:v2" never recommends a v3 release.
+type majorPolicy struct {
+	major string
+}
+
+func (p majorPolicy) Name() string { return "major:" + p.major }
+
+func (p majorPolicy) Select(_ context.Context, _, _ string, versions []string) (string, error) {
+	var matching []string
+	for _, v := range versions {
+		if semver.Major(v) == p.major {
+			matching = append(matching, v)
+		}
+	}
+	return highest(matching), nil
+}
+
+// trackPolicy only advances the patch version within the major.minor
+// the binary was already built with, so it never recommends a minor
+// or major upgrade.
+type trackPolicy struct{}
+
+func (trackPolicy) Name() string { return "track" }
+
+func (trackPolicy) Select(_ context.Context, _, installed string, versions []string) (string, error) {
+	if !semver.IsValid(installed) {
+		return "", nil
+	}
+	track := semver.MajorMinor(installed)
+
+	var matching []string
+	for _, v := range versions {
+		if semver.MajorMinor(v) == track {
+			matching = append(matching, v)
+		}
+	}
+	return highest(matching), nil
+}
+
+// stablePolicy recommends the highest version that is neither a
+// prerelease nor retracted. By convention a module declares its
+// retractions as `retract` directives in the go.mod of its own
+// highest release, so checking for them means fetching that one
+// go.mod and applying its retract set against every candidate.
+type stablePolicy struct {
+	proxies *proxyList
+}
+
+func (stablePolicy) Name() string { return "stable" }
+
+func (p stablePolicy) Select(ctx context.Context, modpath, _ string, versions []string) (string, error) {
+	var stable []string
+	for _, v := range versions {
+		if semver.Prerelease(v) == "" {
+			stable = append(stable, v)
+		}
+	}
+	if len(stable) == 0 {
+		return "", nil
+	}
+	semver.Sort(stable)
+
+	retracts, err := p.retractions(ctx, modpath, highest(versions))
+	if err != nil {
+		// The go.mod declaring retractions couldn't be fetched or
+		// parsed; report the highest stable candidate anyway rather
+		// than failing the whole lookup over a best-effort check.
+		return stable[len(stable)-1], nil
+	}
+
+	for i := len(stable) - 1; i >= 0; i-- {
+		if !isRetracted(stable[i], retracts) {
+			return stable[i], nil
+		}
+	}
+	return "", nil
+}
+
+// retractions fetches modpath's go.mod at version at and returns its
+// retract directives.
+func (p stablePolicy) retractions(ctx context.Context, modpath, at string) ([]*modfile.Retract, error) {
+	data, err := p.proxies.mod(ctx, modpath, at)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching go.mod for %s@%s", modpath, at)
+	}
+	f, err := modfile.ParseLax(modpath+"@"+at+"/go.mod", data, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing go.mod for %s@%s", modpath, at)
+	}
+	return f.Retract, nil
+}
+
+// isRetracted reports whether version falls within any of retracts.
+func isRetracted(version string, retracts []*modfile.Retract) bool {
+	for _, r := range retracts {
+		if semver.Compare(version, r.Low) >= 0 && semver.Compare(version, r.High) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// highest returns the highest of versions in semver order, or "" if
+// versions is empty.
+func highest(versions []string) string {
+	if len(versions) == 0 {
+		return ""
+	}
+	semver.Sort(versions)
+	return versions[len(versions)-1]
+}
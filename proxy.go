@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/bobg/errors"
+	"github.com/bobg/goproxyclient"
+	"golang.org/x/mod/module"
+)
+
+// proxyList resolves, for a given module path, the ordered list of
+// proxies to consult, honoring the GOPROXY, GONOPROXY, and GOPRIVATE
+// environment variables the way the go command itself does: modules
+// matching GONOPROXY or GOPRIVATE skip straight to "direct", and within
+// the remaining list a comma only advances to the next proxy when the
+// failure looks like a not-found, while a pipe advances on any error.
+// See https://go.dev/ref/mod#goproxy-protocol.
+type proxyList struct {
+	goproxy   string
+	gonoproxy string
+	hc        *http.Client
+}
+
+// newProxyList builds a proxyList from the given GOPROXY value and the
+// GONOPROXY/GOPRIVATE values (GOPRIVATE is used only when GONOPROXY is
+// unset, matching `go help module-private`).
+func newProxyList(goproxy, gonoproxy, goprivate string, hc *http.Client) *proxyList {
+	if gonoproxy == "" {
+		gonoproxy = goprivate
+	}
+	return &proxyList{goproxy: goproxy, gonoproxy: gonoproxy, hc: hc}
+}
+
+// effectiveGoproxy returns the GOPROXY value to use for modpath: just
+// "direct" if modpath matches GONOPROXY/GOPRIVATE, otherwise p.goproxy
+// unchanged.
+func (p *proxyList) effectiveGoproxy(modpath string) string {
+	if module.MatchPrefixPatterns(p.gonoproxy, modpath) {
+		return "direct"
+	}
+	return p.goproxy
+}
+
+// list returns the available versions of modpath, trying each proxy in
+// turn as described in the proxyList doc comment.
+func (p *proxyList) list(ctx context.Context, modpath string) ([]string, error) {
+	goproxy := p.effectiveGoproxy(modpath)
+
+	var lastErr error
+
+	for goproxy != "" {
+		var (
+			part        string
+			afterAnyErr bool
+		)
+		if end := strings.IndexAny(goproxy, ",|"); end >= 0 {
+			part, afterAnyErr, goproxy = goproxy[:end], goproxy[end] == '|', goproxy[end+1:]
+		} else {
+			part, goproxy = goproxy, ""
+		}
+
+		versions, err := p.listOne(ctx, part, modpath)
+		if err == nil {
+			return versions, nil
+		}
+		if !afterAnyErr && !isNotFound(err) {
+			return nil, err
+		}
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no proxy configured for %s", modpath)
+	}
+	return nil, lastErr
+}
+
+// listOne queries the single proxy named by part (which may be the
+// sentinel "direct" or "off") for modpath's available versions.
+func (p *proxyList) listOne(ctx context.Context, part, modpath string) ([]string, error) {
+	var (
+		versions []string
+		err      error
+	)
+
+	switch part {
+	case "":
+		return nil, NotFoundError{Module: modpath}
+	case "off":
+		return nil, fmt.Errorf("GOPROXY=off: not looking up %s", modpath)
+	case "direct":
+		versions, err = p.listDirect(ctx, modpath)
+	default:
+		versions, err = goproxyclient.New(part, p.hc).List(ctx, modpath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Some proxies (and goproxyclient itself) don't trim trailing
+	// whitespace from @v/list lines; do it here so callers never see a
+	// version string that fails semver.IsValid or breaks a URL.
+	for i, v := range versions {
+		versions[i] = strings.TrimSpace(v)
+	}
+	if len(versions) == 0 {
+		// An empty @v/list response means the module is unknown to this
+		// proxy, per modfetch semantics, even though the HTTP request
+		// itself succeeded.
+		return nil, NotFoundError{Module: modpath}
+	}
+	return versions, nil
+}
+
+// listDirect looks up modpath's available versions straight from its
+// VCS, the way GOPROXY=direct would, by shelling out to `go list -m
+// -versions` (which already knows how to turn a module path into a
+// repository URL and talk to it).
+func (p *proxyList) listDirect(ctx context.Context, modpath string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "go", "list", "-m", "-versions", modpath)
+	cmd.Env = goEnv("GOPROXY=direct")
+
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && strings.Contains(string(ee.Stderr), "no matching versions") {
+			return nil, NotFoundError{Module: modpath}
+		}
+		return nil, errors.Wrapf(err, "running go list -m -versions %s", modpath)
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) <= 1 {
+		return nil, NotFoundError{Module: modpath}
+	}
+	return fields[1:], nil
+}
+
+// mod fetches the go.mod content for modpath at version, trying each
+// proxy in turn exactly as list does.
+func (p *proxyList) mod(ctx context.Context, modpath, version string) ([]byte, error) {
+	goproxy := p.effectiveGoproxy(modpath)
+
+	var lastErr error
+
+	for goproxy != "" {
+		var (
+			part        string
+			afterAnyErr bool
+		)
+		if end := strings.IndexAny(goproxy, ",|"); end >= 0 {
+			part, afterAnyErr, goproxy = goproxy[:end], goproxy[end] == '|', goproxy[end+1:]
+		} else {
+			part, goproxy = goproxy, ""
+		}
+
+		data, err := p.modOne(ctx, part, modpath, version)
+		if err == nil {
+			return data, nil
+		}
+		if !afterAnyErr && !isNotFound(err) {
+			return nil, err
+		}
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no proxy configured for %s", modpath)
+	}
+	return nil, lastErr
+}
+
+// modOne fetches modpath's go.mod content at version from the single
+// proxy named by part (which may be the sentinel "direct" or "off").
+func (p *proxyList) modOne(ctx context.Context, part, modpath, version string) ([]byte, error) {
+	switch part {
+	case "":
+		return nil, NotFoundError{Module: modpath}
+	case "off":
+		return nil, fmt.Errorf("GOPROXY=off: not looking up %s", modpath)
+	case "direct":
+		return p.modDirect(ctx, modpath, version)
+	default:
+		rc, err := goproxyclient.New(part, p.hc).Mod(ctx, modpath, version)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+}
+
+// modDirect fetches modpath's go.mod content at version straight from
+// its VCS, by shelling out to `go mod download` (which already knows
+// how to turn a module path into a repository URL and extract its
+// go.mod).
+func (p *proxyList) modDirect(ctx context.Context, modpath, version string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "go", "mod", "download", "-json", modpath+"@"+version)
+	cmd.Dir = os.TempDir()
+	cmd.Env = goEnv("GOPROXY=direct", "GOTOOLCHAIN=auto")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "running go mod download -json %s@%s", modpath, version)
+	}
+
+	var info struct {
+		GoMod string
+		Error string
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, errors.Wrapf(err, "parsing go mod download output for %s@%s", modpath, version)
+	}
+	if info.Error != "" {
+		return nil, fmt.Errorf("downloading %s@%s: %s", modpath, version, info.Error)
+	}
+	return os.ReadFile(info.GoMod)
+}
+
+// goEnv returns the current process environment with the given
+// "KEY=VALUE" overrides applied. It's not enough to just append the
+// overrides to os.Environ(): when a variable appears twice in a
+// process's environment, the C library (and so the go command) reads
+// whichever value comes first, so a naively appended override would
+// be silently ignored whenever it was already set in our environment.
+func goEnv(overrides ...string) []string {
+	keys := make(map[string]bool, len(overrides))
+	for _, kv := range overrides {
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			keys[kv[:i]] = true
+		}
+	}
+
+	env := append([]string(nil), overrides...)
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i >= 0 && keys[kv[:i]] {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}